@@ -0,0 +1,197 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archiveName turns a served directory path into a safe file name for the
+// Content-Disposition header, e.g. "." or "/" becomes "archive".
+func archiveName(dirPath string) string {
+	name := path.Base(path.Clean(dirPath))
+	if name == "." || name == "/" || name == "" {
+		name = "archive"
+	}
+	return name
+}
+
+// escapesRoot reports whether a symlink at fsPath resolves outside root.
+func escapesRoot(root, fsPath string) bool {
+	resolved, err := filepath.EvalSymlinks(fsPath)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return true
+	}
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func handleArchiveGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dirPath := getQueryValueOrDefault(query, "p", ".")
+	format := getQueryValueOrDefault(query, "format", "zip")
+	if format != "zip" && format != "tgz" {
+		http.Error(w, "format must be zip or tgz", http.StatusBadRequest)
+		return
+	}
+
+	// writeZipArchive/writeTarGzArchive walk *serveDir on the local
+	// filesystem directly rather than through Storage, so they can't see a
+	// non-local backend's actual contents.
+	if err := requireLocalBackend(); err != nil {
+		http.Error(w, "Archive downloads require the local storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	root, err := filepath.Abs(*serveDir)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	fullPath, err := filepath.Abs(path.Join(*serveDir, dirPath))
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		http.Error(w, "Path escapes served directory", http.StatusBadRequest)
+		return
+	}
+
+	name := archiveName(dirPath)
+	if format == "zip" {
+		w.Header().Set("content-type", "application/zip")
+		w.Header().Set("content-disposition", "attachment; filename=\""+name+".zip\"")
+		if err := writeZipArchive(w, root, fullPath); err != nil {
+			log.Printf("Unable to stream zip archive of %s: %v", fullPath, err)
+		}
+		return
+	}
+
+	w.Header().Set("content-type", "application/gzip")
+	w.Header().Set("content-disposition", "attachment; filename=\""+name+".tar.gz\"")
+	if err := writeTarGzArchive(w, root, fullPath); err != nil {
+		log.Printf("Unable to stream tar.gz archive of %s: %v", fullPath, err)
+	}
+}
+
+func writeZipArchive(w io.Writer, root, dirPath string) error {
+	zipWriter := zip.NewWriter(w)
+	defer func() {
+		if err := zipWriter.Close(); err != nil {
+			log.Printf("Unable to close zip writer: %v", err)
+		}
+	}()
+
+	return filepath.WalkDir(dirPath, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 && escapesRoot(root, fsPath) {
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dirPath, fsPath)
+		if err != nil {
+			return err
+		}
+
+		entryWriter, err := zipWriter.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		defer func(file *os.File) {
+			if err := file.Close(); err != nil {
+				log.Printf("Unable to close file: %v", err)
+			}
+		}(file)
+
+		_, err = io.Copy(entryWriter, file)
+		return err
+	})
+}
+
+func writeTarGzArchive(w io.Writer, root, dirPath string) error {
+	gzipWriter := gzip.NewWriter(w)
+	defer func() {
+		if err := gzipWriter.Close(); err != nil {
+			log.Printf("Unable to close gzip writer: %v", err)
+		}
+	}()
+
+	tarWriter := tar.NewWriter(gzipWriter)
+	defer func() {
+		if err := tarWriter.Close(); err != nil {
+			log.Printf("Unable to close tar writer: %v", err)
+		}
+	}()
+
+	return filepath.WalkDir(dirPath, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 && escapesRoot(root, fsPath) {
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dirPath, fsPath)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(fsPath)
+		if err != nil {
+			return err
+		}
+		defer func(file *os.File) {
+			if err := file.Close(); err != nil {
+				log.Printf("Unable to close file: %v", err)
+			}
+		}(file)
+
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+}
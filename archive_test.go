@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleArchiveGetRejectsEscape(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withServeDir(t, root)
+	withLocalFileStorage(t, root)
+
+	outside := filepath.Join(filepath.Dir(root), "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []string{"../outside", "../../../../etc"}
+	for _, p := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/archive?p="+p+"&format=zip", nil)
+		rec := httptest.NewRecorder()
+
+		handleArchiveGet(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("p=%q: status = %d, want %d", p, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleArchiveGetAllowsWithinRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withServeDir(t, root)
+	withLocalFileStorage(t, root)
+
+	req := httptest.NewRequest(http.MethodGet, "/archive?p=.&format=zip", nil)
+	rec := httptest.NewRecorder()
+
+	handleArchiveGet(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d; body: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if ct := rec.Header().Get("content-type"); ct != "application/zip" {
+		t.Errorf("content-type = %q, want application/zip", ct)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const dirConfigFileName = ".updown.yml"
+
+// dirConfig is the shape of a .updown.yml file. Pointer fields distinguish
+// "unset" (inherit from parent) from an explicit false.
+type dirConfig struct {
+	Upload   *bool             `yaml:"upload"`
+	Delete   *bool             `yaml:"delete"`
+	Title    string            `yaml:"title"`
+	Redirect map[string]string `yaml:"redirect"`
+}
+
+// dirPolicy is the effective, fully-merged configuration for a directory
+// after walking down from *serveDir.
+type dirPolicy struct {
+	Upload   bool
+	Delete   bool
+	Title    string
+	Redirect map[string]string
+}
+
+func defaultDirPolicy() dirPolicy {
+	return dirPolicy{Upload: false, Delete: false}
+}
+
+// merge applies cfg on top of the policy, with explicit fields in cfg
+// overriding whatever the parent directories already established.
+func (p dirPolicy) merge(cfg *dirConfig) dirPolicy {
+	if cfg == nil {
+		return p
+	}
+	if cfg.Upload != nil {
+		p.Upload = *cfg.Upload
+	}
+	if cfg.Delete != nil {
+		p.Delete = *cfg.Delete
+	}
+	if cfg.Title != "" {
+		p.Title = cfg.Title
+	}
+	if len(cfg.Redirect) > 0 {
+		merged := make(map[string]string, len(p.Redirect)+len(cfg.Redirect))
+		for k, v := range p.Redirect {
+			merged[k] = v
+		}
+		for k, v := range cfg.Redirect {
+			merged[k] = v
+		}
+		p.Redirect = merged
+	}
+	return p
+}
+
+type cachedDirConfig struct {
+	mtime  time.Time
+	config *dirConfig
+}
+
+var dirConfigCache sync.Map // map[string]cachedDirConfig, keyed by absolute directory path
+
+// loadDirConfig reads and parses the .updown.yml file in dir, if any,
+// returning nil when the directory has no config file. Results are
+// cached and invalidated by the file's mtime.
+func loadDirConfig(dir string) (*dirConfig, error) {
+	configPath := filepath.Join(dir, dirConfigFileName)
+
+	info, err := os.Stat(configPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := dirConfigCache.Load(configPath); ok {
+		entry := cached.(cachedDirConfig)
+		if entry.mtime.Equal(info.ModTime()) {
+			return entry.config, nil
+		}
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg dirConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	dirConfigCache.Store(configPath, cachedDirConfig{mtime: info.ModTime(), config: &cfg})
+	return &cfg, nil
+}
+
+// effectiveDirPolicy walks from *serveDir down to the (absolute, within
+// *serveDir) target directory, merging each level's .updown.yml so that
+// child directories override their parents.
+func effectiveDirPolicy(fullPath string) (dirPolicy, error) {
+	// loadDirConfig reads .updown.yml straight off the local filesystem, so
+	// this can't see a non-local backend's actual directory tree; fail
+	// loudly rather than silently falling back to defaultDirPolicy(), the
+	// same trade-off /archive and /search already made.
+	if err := requireLocalBackend(); err != nil {
+		return dirPolicy{}, err
+	}
+
+	root, err := filepath.Abs(*serveDir)
+	if err != nil {
+		return dirPolicy{}, err
+	}
+
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		return dirPolicy{}, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return dirPolicy{}, errPathEscapesRoot
+	}
+
+	policy := defaultDirPolicy()
+	if rel == "." {
+		cfg, err := loadDirConfig(root)
+		if err != nil {
+			return dirPolicy{}, err
+		}
+		return policy.merge(cfg), nil
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	current := root
+	cfg, err := loadDirConfig(current)
+	if err != nil {
+		return dirPolicy{}, err
+	}
+	policy = policy.merge(cfg)
+
+	for _, segment := range segments {
+		current = filepath.Join(current, segment)
+		cfg, err := loadDirConfig(current)
+		if err != nil {
+			return dirPolicy{}, err
+		}
+		policy = policy.merge(cfg)
+	}
+
+	return policy, nil
+}
+
+// resolveRedirect returns the configured redirect target for fileName in
+// dir, if any.
+func resolveRedirect(policy dirPolicy, fileName string) (string, bool) {
+	target, ok := policy.Redirect[fileName]
+	return target, ok
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func withServeDir(t *testing.T, dir string) {
+	t.Helper()
+	previous := serveDir
+	serveDir = &dir
+	t.Cleanup(func() { serveDir = previous })
+}
+
+// withLocalFileStorage points the global fileStorage at a *localFS rooted
+// at dir, as main() would for -backend local, so code gated by
+// requireLocalBackend runs during the test.
+func withLocalFileStorage(t *testing.T, dir string) {
+	t.Helper()
+	previous := fileStorage
+	fileStorage = newLocalFS(dir)
+	t.Cleanup(func() { fileStorage = previous })
+}
+
+func TestEffectiveDirPolicyMergesParentAndChild(t *testing.T) {
+	root := t.TempDir()
+	child := filepath.Join(root, "sub")
+	if err := os.Mkdir(child, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig := func(dir, contents string) {
+		if err := os.WriteFile(filepath.Join(dir, dirConfigFileName), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(root, "upload: true\ndelete: false\ntitle: Root\n")
+	writeConfig(child, "delete: true\n")
+
+	withServeDir(t, root)
+	withLocalFileStorage(t, root)
+
+	policy, err := effectiveDirPolicy(child)
+	if err != nil {
+		t.Fatalf("effectiveDirPolicy: %v", err)
+	}
+	if !policy.Upload {
+		t.Error("Upload should be inherited as true from the parent")
+	}
+	if !policy.Delete {
+		t.Error("Delete should be overridden to true by the child")
+	}
+	if policy.Title != "Root" {
+		t.Errorf("Title = %q, want inherited %q", policy.Title, "Root")
+	}
+}
+
+func TestEffectiveDirPolicyRejectsEscape(t *testing.T) {
+	root := t.TempDir()
+	withServeDir(t, root)
+	withLocalFileStorage(t, root)
+
+	outside := filepath.Join(filepath.Dir(root), "outside")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := effectiveDirPolicy(outside); !errors.Is(err, errPathEscapesRoot) {
+		t.Errorf("effectiveDirPolicy(outside root): want errPathEscapesRoot, got %v", err)
+	}
+}
+
+func TestEffectiveDirPolicyRequiresLocalBackend(t *testing.T) {
+	root := t.TempDir()
+	withServeDir(t, root)
+
+	previous := fileStorage
+	fileStorage = nil
+	t.Cleanup(func() { fileStorage = previous })
+
+	if _, err := effectiveDirPolicy(root); !errors.Is(err, errNonLocalBackend) {
+		t.Errorf("effectiveDirPolicy with non-local backend: want errNonLocalBackend, got %v", err)
+	}
+}
@@ -6,13 +6,12 @@ import (
 	"html/template"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,14 +33,22 @@ var tmplRoot = template.Must(template.New("Root").Parse(`
 		</style>
 	</head>
 	<body>
-		<h1>Updown</h1>
+		<h1>{{if .Title}}{{.Title}}{{else}}Updown{{end}}</h1>
 		<p>Welcome to updown.</p>
+		{{if .CanUpload}}
 		<form method="post" action="/upload" enctype="multipart/form-data">
+			<input type="hidden" name="p" value="{{.ServePathDir}}">
 			<input type="file" name="file">
 			<button type="submit">Upload</button>
 		</form>
+		{{end}}
+		<form method="get" action="/search">
+			<input type="text" name="q" placeholder="Search files">
+			<button type="submit">Search</button>
+		</form>
 		<h2>Serving files</h2>
 		<p>Path: {{.FullPath}}</p>
+		<p><a href="{{.ArchiveURL}}">Download as ZIP</a></p>
 		<ul>
 		{{range .Files}}
 			<li><a href="{{.URL}}">{{.Name}}</a> {{.Type}}</li>
@@ -93,7 +100,17 @@ func handleRootGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		entries, err := os.ReadDir(fullPath)
+		entries, err := fileStorage.List(servePathRoot)
+		if err != nil {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		policy, err := effectiveDirPolicy(fullPath)
+		if errors.Is(err, errNonLocalBackend) {
+			http.Error(w, "Directory policy requires the local storage backend", http.StatusNotImplemented)
+			return
+		}
 		if err != nil {
 			http.Error(w, "", http.StatusInternalServerError)
 			return
@@ -104,19 +121,27 @@ func handleRootGet(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "", http.StatusInternalServerError)
 			return
 		}
+		archiveURL, err := addQueryToPath("/archive", map[string]string{"p": servePathRoot, "format": "zip"})
+		if err != nil {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
 		fileEntries := []fileEntry{{URL: entryURL, Name: "../", Type: "<DIR>"}}
 		for _, entry := range entries {
 			var (
 				entryType string
 				fileName  string
 			)
-			if entry.IsDir() {
-				entryURL, err = addQueryToPath("/", map[string]string{"p": path.Join(servePathRoot, entry.Name())})
+			if entry.IsDir {
+				entryURL, err = addQueryToPath("/", map[string]string{"p": path.Join(servePathRoot, entry.Name)})
 				entryType = "<DIR>"
-				fileName = entry.Name() + "/"
+				fileName = entry.Name + "/"
+			} else if redirectTarget, ok := resolveRedirect(policy, entry.Name); ok {
+				entryURL = redirectTarget
+				fileName = entry.Name
 			} else {
-				entryURL, err = addQueryToPath("/download", map[string]string{"p": path.Join(servePathRoot, entry.Name())})
-				fileName = entry.Name()
+				entryURL, err = addQueryToPath("/download", map[string]string{"p": path.Join(servePathRoot, entry.Name)})
+				fileName = entry.Name
 			}
 			if err != nil {
 				http.Error(w, "", http.StatusInternalServerError)
@@ -126,8 +151,13 @@ func handleRootGet(w http.ResponseWriter, r *http.Request) {
 		}
 
 		tmplData := map[string]any{
-			"FullPath": fullPath,
-			"Files":    fileEntries,
+			"FullPath":     fullPath,
+			"Files":        fileEntries,
+			"Title":        policy.Title,
+			"CanUpload":    policy.Upload,
+			"CanDelete":    policy.Delete,
+			"ServePathDir": servePathRoot,
+			"ArchiveURL":   archiveURL,
 		}
 
 		err = tmplRoot.Execute(w, tmplData)
@@ -142,93 +172,94 @@ func handleRootGet(w http.ResponseWriter, r *http.Request) {
 
 func handleDownloadGet(w http.ResponseWriter, r *http.Request) {
 	inputPath := getQueryValueOrDefault(r.URL.Query(), "p", ".")
-	fsPath := path.Join(*serveDir, inputPath)
-	file, err := os.Open(fsPath)
+
+	info, err := fileStorage.Stat(inputPath)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	file, err := fileStorage.Open(inputPath)
 	if err != nil {
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
-	defer func(file *os.File) {
+	defer func(file io.ReadCloser) {
 		err := file.Close()
 		if err != nil {
 			log.Printf("Unable to close file: %v", err)
 		}
 	}(file)
+
 	w.Header().Add("content-type", "application/octet-stream")
-	w.Header().Add("content-disposition", "attachment; filename=\""+path.Base(fsPath)+"\"")
-	_, err = io.Copy(w, file)
-	if err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+	w.Header().Add("content-disposition", "attachment; filename=\""+path.Base(inputPath)+"\"")
+
+	// Range requests only work when the backend hands back a seekable
+	// reader; backends that can't seek (e.g. a plain object GET) fall
+	// back to a full, non-resumable copy.
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(inputPath), info.ModTime, seeker)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Unable to copy file to response: %v", err)
+	}
 }
 
-func handleUploadPost(w http.ResponseWriter, r *http.Request) {
-	readPart := func(part *multipart.Part) (ok bool, err error) {
-		defer func(part *multipart.Part) {
-			err := part.Close()
-			if err != nil {
-				log.Printf("Unable to close file: %v", err)
-			}
-		}(part)
-
-		if part.FormName() != "file" {
-			return false, nil
-		}
-
-		fileName := path.Base(part.FileName())
-		outputPath := path.Join(*outputDir, fileName)
-
-		log.Printf("Receive upload of file name %s. Writing to %s.", part.FileName(), outputPath)
-
-		file, err := os.Create(outputPath)
-		if err != nil {
-			return false, err
-		}
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Printf("Unable to close file: %v", err)
-			}
-		}(file)
+func handleFileDelete(w http.ResponseWriter, r *http.Request) {
+	inputPath := getQueryValueOrDefault(r.URL.Query(), "p", "")
+	if inputPath == "" {
+		http.Error(w, "Missing p parameter", http.StatusBadRequest)
+		return
+	}
 
-		_, err = io.Copy(file, part)
-		if err != nil {
-			return false, err
-		}
-		return true, nil
+	root, err := filepath.Abs(*serveDir)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	fullPath, err := filepath.Abs(path.Join(*serveDir, inputPath))
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		http.Error(w, "Path escapes served directory", http.StatusBadRequest)
+		return
 	}
 
-	reader, err := r.MultipartReader()
+	policy, err := effectiveDirPolicy(filepath.Dir(fullPath))
+	if errors.Is(err, errNonLocalBackend) {
+		http.Error(w, "Directory policy requires the local storage backend", http.StatusNotImplemented)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Unable to read multipart form data", http.StatusBadRequest)
-		log.Printf("Unable to read multipart form data: %+v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if !policy.Delete {
+		http.Error(w, "Delete not allowed for this directory", http.StatusForbidden)
 		return
 	}
 
-	for {
-		part, err := reader.NextPart()
-		if errors.Is(err, io.EOF) {
-			http.Error(w, "", http.StatusBadRequest)
-			return
-		}
-		ok, err := readPart(part)
-		if err != nil {
-			http.Error(w, "", http.StatusBadRequest)
-			return
-		}
-		if ok {
-			break
-		}
+	if err := fileStorage.Delete(filepath.ToSlash(rel)); err != nil {
+		log.Printf("Unable to delete %s: %v", rel, err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 type ByMethod struct {
-	Get  http.HandlerFunc
-	Post http.HandlerFunc
+	Get    http.HandlerFunc
+	Post   http.HandlerFunc
+	Delete http.HandlerFunc
 }
 
 func routeByMethod(byMethod ByMethod) http.HandlerFunc {
@@ -244,6 +275,11 @@ func routeByMethod(byMethod ByMethod) http.HandlerFunc {
 				byMethod.Post(w, r)
 				return
 			}
+		case http.MethodDelete:
+			if byMethod.Delete != nil {
+				byMethod.Delete(w, r)
+				return
+			}
 		default:
 		}
 
@@ -263,18 +299,35 @@ func (l *loggerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 var (
 	outputDir *string
 	serveDir  *string
+
+	fileStorage Storage
 )
 
 func main() {
 	portNum := flag.Int("p", 6600, "port number")
 	outputDir = flag.String("o", ".", "output directory")
 	serveDir = flag.String("s", ".", "directory to serve")
+	searchRefreshMinutes := flag.Int("search-refresh", 10, "minutes between search index rebuilds")
+	backend := flag.String("backend", "local", "storage backend: local, or a connection string such as s3://bucket or gs://bucket")
 	flag.Parse()
 
+	storage, err := NewStorage(*backend, *serveDir)
+	if err != nil {
+		log.Fatalf("Unable to initialize storage backend %q: %v", *backend, err)
+	}
+	fileStorage = storage
+
+	go refreshSearchIndex(time.Duration(*searchRefreshMinutes) * time.Minute)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", routeByMethod(ByMethod{Get: handleRootGet}))
 	mux.HandleFunc("/upload", routeByMethod(ByMethod{Post: handleUploadPost}))
 	mux.HandleFunc("/download", routeByMethod(ByMethod{Get: handleDownloadGet}))
+	mux.HandleFunc("/uploads", handleUploads)
+	mux.HandleFunc("/uploads/", handleUploads)
+	mux.HandleFunc("/search", routeByMethod(ByMethod{Get: handleSearchGet}))
+	mux.HandleFunc("/file", routeByMethod(ByMethod{Delete: handleFileDelete}))
+	mux.HandleFunc("/archive", routeByMethod(ByMethod{Get: handleArchiveGet}))
 	log.Printf("Listening to port %v", *portNum)
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(*portNum), &loggerMiddleware{Handler: mux}))
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchEntry describes a single indexed file.
+type searchEntry struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+}
+
+// searchResult is a searchEntry enriched with the URL needed to download it.
+type searchResult struct {
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+	URL   string    `json:"url"`
+}
+
+// searchIndex holds an in-memory snapshot of *serveDir, rebuilt
+// periodically by refreshSearchIndex.
+type searchIndex struct {
+	mu      sync.RWMutex
+	entries []searchEntry
+}
+
+var globalSearchIndex = &searchIndex{}
+
+func (idx *searchIndex) rebuild() {
+	// Like the archive handlers, the index is built by walking *serveDir on
+	// the local filesystem directly; skip rebuilding against a non-local
+	// backend rather than silently indexing the wrong files.
+	if err := requireLocalBackend(); err != nil {
+		log.Printf("Search index not rebuilt: %v", err)
+		return
+	}
+
+	var entries []searchEntry
+	err := filepath.WalkDir(*serveDir, func(fsPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(*serveDir, fsPath)
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, searchEntry{
+			Path:  filepath.ToSlash(relPath),
+			Size:  info.Size(),
+			MTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		log.Printf("Unable to walk %s for search index: %v", *serveDir, err)
+	}
+
+	idx.mu.Lock()
+	idx.entries = entries
+	idx.mu.Unlock()
+
+	log.Printf("Search index refreshed: %d files", len(entries))
+}
+
+// search returns up to limit entries whose path matches query, either as a
+// case-insensitive substring or, if query contains a glob meta character,
+// as a filepath.Match glob pattern.
+func (idx *searchIndex) search(query string, limit int) []searchEntry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	isGlob := strings.ContainsAny(query, "*?[")
+	lowerQuery := strings.ToLower(query)
+
+	var results []searchEntry
+	for _, entry := range idx.entries {
+		var matched bool
+		if isGlob {
+			matched, _ = path.Match(query, entry.Path)
+		} else {
+			matched = strings.Contains(strings.ToLower(entry.Path), lowerQuery)
+		}
+		if matched {
+			results = append(results, entry)
+			if len(results) >= limit {
+				break
+			}
+		}
+	}
+	return results
+}
+
+// refreshSearchIndex builds the index immediately and then rebuilds it
+// every interval until the process exits. interval <= 0 disables periodic
+// rebuilds (time.Tick panics on a non-positive duration), leaving the
+// initial build in place.
+func refreshSearchIndex(interval time.Duration) {
+	globalSearchIndex.rebuild()
+	if interval <= 0 {
+		log.Printf("Search index refresh disabled (interval %s <= 0)", interval)
+		return
+	}
+	for range time.Tick(interval) {
+		globalSearchIndex.rebuild()
+	}
+}
+
+func handleSearchGet(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := getQueryValueOrDefault(query, "q", "")
+	if q == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := requireLocalBackend(); err != nil {
+		http.Error(w, "Search requires the local storage backend", http.StatusNotImplemented)
+		return
+	}
+
+	limit := 50
+	if limitStr := getQueryValueOrDefault(query, "limit", ""); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+
+	entries := globalSearchIndex.search(q, limit)
+	results := make([]searchResult, 0, len(entries))
+	for _, entry := range entries {
+		downloadURL, err := addQueryToPath("/download", map[string]string{"p": entry.Path})
+		if err != nil {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		results = append(results, searchResult{
+			Path:  entry.Path,
+			Size:  entry.Size,
+			MTime: entry.MTime,
+			URL:   downloadURL,
+		})
+	}
+
+	w.Header().Set("content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Unable to encode search results: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errPathEscapesRoot is returned when a requested path would resolve
+// outside a Storage backend's root, e.g. via ".." segments.
+var errPathEscapesRoot = errors.New("path escapes storage root")
+
+// Entry describes a single file or directory returned by Storage.List or
+// Storage.Stat.
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Storage abstracts the filesystem operations updown needs so that
+// *serveDir can be backed by something other than the local disk.
+type Storage interface {
+	Open(path string) (io.ReadCloser, error)
+	Create(path string) (io.WriteCloser, error)
+	List(path string) ([]Entry, error)
+	Stat(path string) (Entry, error)
+	Delete(path string) error
+}
+
+// renamer is an optional capability a Storage backend may implement to
+// support atomic renames, used by the content-addressed upload path.
+// Backends without it fall back to a copy-then-delete.
+type renamer interface {
+	Rename(oldPath, newPath string) error
+}
+
+// localFS is the default Storage backend: it reads and writes directly
+// under root on the local disk.
+type localFS struct {
+	root string
+}
+
+func newLocalFS(root string) *localFS {
+	return &localFS{root: root}
+}
+
+// resolve joins p onto root and rejects the result if it would fall
+// outside root, e.g. because p contains ".." segments.
+func (l *localFS) resolve(p string) (string, error) {
+	full := filepath.Join(l.root, filepath.FromSlash(p))
+
+	rel, err := filepath.Rel(l.root, full)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errPathEscapesRoot
+	}
+	return full, nil
+}
+
+func (l *localFS) Open(p string) (io.ReadCloser, error) {
+	full, err := l.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(full)
+}
+
+func (l *localFS) Create(p string) (io.WriteCloser, error) {
+	full, err := l.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Create(full)
+}
+
+func (l *localFS) List(p string) ([]Entry, error) {
+	full, err := l.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(full)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		info, err := d.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name:    d.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   d.IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+func (l *localFS) Stat(p string) (Entry, error) {
+	full, err := l.resolve(p)
+	if err != nil {
+		return Entry{}, err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime(), IsDir: info.IsDir()}, nil
+}
+
+func (l *localFS) Delete(p string) error {
+	full, err := l.resolve(p)
+	if err != nil {
+		return err
+	}
+	return os.Remove(full)
+}
+
+func (l *localFS) Rename(oldPath, newPath string) error {
+	fullOld, err := l.resolve(oldPath)
+	if err != nil {
+		return err
+	}
+	fullNew, err := l.resolve(newPath)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fullOld, fullNew)
+}
+
+// errNonLocalBackend is returned by handlers that still walk *serveDir
+// directly on the local filesystem instead of going through Storage, when
+// fileStorage is backed by something else (e.g. S3 or GCS). Without this
+// check they'd silently serve or index whatever happens to be on local
+// disk rather than the configured backend.
+var errNonLocalBackend = errors.New("this operation is not supported with a non-local storage backend")
+
+// requireLocalBackend returns errNonLocalBackend unless fileStorage is the
+// local-disk backend.
+func requireLocalBackend() error {
+	if _, ok := fileStorage.(*localFS); !ok {
+		return errNonLocalBackend
+	}
+	return nil
+}
+
+// NewStorage builds a Storage backend from the -backend flag value. An
+// empty value or "local" serves files directly off serveDir. Any other
+// value is parsed as a connection string whose scheme selects the driver:
+// s3://bucket[?region=us-east-1] or gs://bucket.
+func NewStorage(backend, serveDir string) (Storage, error) {
+	if backend == "" || backend == "local" {
+		return newLocalFS(serveDir), nil
+	}
+
+	connURL, err := url.Parse(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	switch connURL.Scheme {
+	case "s3":
+		return newS3Storage(connURL)
+	case "gs", "gcs":
+		return newGCSStorage(connURL)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", backend)
+	}
+}
+
+// copyThenDelete implements a rename for backends that don't support one
+// natively: it streams oldPath to newPath and removes oldPath once the
+// copy succeeds.
+func copyThenDelete(s Storage, oldPath, newPath string) error {
+	src, err := s.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := s.Create(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return s.Delete(oldPath)
+}
+
+// renameStorage renames oldPath to newPath on s, using the backend's native
+// Rename when available and falling back to copyThenDelete otherwise.
+func renameStorage(s Storage, oldPath, newPath string) error {
+	if r, ok := s.(renamer); ok {
+		return r.Rename(oldPath, newPath)
+	}
+	return copyThenDelete(s, oldPath, newPath)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsStorage is a Storage backend for Google Cloud Storage, selected via a
+// "gs://bucket" connection string.
+type gcsStorage struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+}
+
+func newGCSStorage(connURL *url.URL) (Storage, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsStorage{client: client, bucket: client.Bucket(connURL.Host)}, nil
+}
+
+func (g *gcsStorage) key(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+func (g *gcsStorage) Open(p string) (io.ReadCloser, error) {
+	reader, err := g.bucket.Object(g.key(p)).NewReader(context.Background())
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Create streams directly to the object writer; no in-memory buffering of
+// the upload is needed.
+func (g *gcsStorage) Create(p string) (io.WriteCloser, error) {
+	return g.bucket.Object(g.key(p)).NewWriter(context.Background()), nil
+}
+
+func (g *gcsStorage) List(p string) ([]Entry, error) {
+	ctx := context.Background()
+
+	prefix := g.key(p)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	it := g.bucket.Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+
+	var entries []Entry
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if attrs.Prefix != "" {
+			name := strings.TrimSuffix(strings.TrimPrefix(attrs.Prefix, prefix), "/")
+			entries = append(entries, Entry{Name: name, IsDir: true})
+			continue
+		}
+
+		name := strings.TrimPrefix(attrs.Name, prefix)
+		if name == "" {
+			continue
+		}
+		entries = append(entries, Entry{Name: name, Size: attrs.Size, ModTime: attrs.Updated})
+	}
+	return entries, nil
+}
+
+func (g *gcsStorage) Stat(p string) (Entry, error) {
+	attrs, err := g.bucket.Object(g.key(p)).Attrs(context.Background())
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return Entry{}, os.ErrNotExist
+		}
+		return Entry{}, err
+	}
+	return Entry{Name: path.Base(p), Size: attrs.Size, ModTime: attrs.Updated}, nil
+}
+
+func (g *gcsStorage) Delete(p string) error {
+	return g.bucket.Object(g.key(p)).Delete(context.Background())
+}
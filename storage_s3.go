@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// s3Storage is a Storage backend for S3-compatible object stores, selected
+// via a "s3://bucket[?region=us-east-1]" connection string.
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Storage(connURL *url.URL) (Storage, error) {
+	ctx := context.Background()
+
+	var opts []func(*config.LoadOptions) error
+	if region := connURL.Query().Get("region"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Storage{
+		client: s3.NewFromConfig(cfg),
+		bucket: connURL.Host,
+	}, nil
+}
+
+func (s *s3Storage) key(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+func (s *s3Storage) Open(p string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Create streams writes to an S3 object via a pipe, so callers never need
+// to buffer the whole upload in memory. The returned writer's Close blocks
+// until the PutObject call has finished.
+func (s *s3Storage) Create(p string) (io.WriteCloser, error) {
+	pipeReader, pipeWriter := io.Pipe()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(p)),
+			Body:   pipeReader,
+		})
+		pipeReader.CloseWithError(err)
+		done <- err
+	}()
+
+	return &pipeUploadWriter{pipeWriter: pipeWriter, done: done}, nil
+}
+
+func (s *s3Storage) List(p string) ([]Entry, error) {
+	prefix := s.key(p)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	out, err := s.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(out.CommonPrefixes)+len(out.Contents))
+	for _, commonPrefix := range out.CommonPrefixes {
+		name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(commonPrefix.Prefix), prefix), "/")
+		entries = append(entries, Entry{Name: name, IsDir: true})
+	}
+	for _, object := range out.Contents {
+		name := strings.TrimPrefix(aws.ToString(object.Key), prefix)
+		if name == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Name:    name,
+			Size:    aws.ToInt64(object.Size),
+			ModTime: aws.ToTime(object.LastModified),
+		})
+	}
+	return entries, nil
+}
+
+func (s *s3Storage) Stat(p string) (Entry, error) {
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return Entry{}, os.ErrNotExist
+		}
+		return Entry{}, err
+	}
+	return Entry{
+		Name:    path.Base(p),
+		Size:    aws.ToInt64(out.ContentLength),
+		ModTime: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *s3Storage) Delete(p string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(p)),
+	})
+	return err
+}
+
+// pipeUploadWriter adapts an io.Pipe into an io.WriteCloser whose Close
+// waits for the background upload goroutine to finish and surfaces its
+// error, if any.
+type pipeUploadWriter struct {
+	pipeWriter *io.PipeWriter
+	done       chan error
+}
+
+func (p *pipeUploadWriter) Write(b []byte) (int, error) {
+	return p.pipeWriter.Write(b)
+}
+
+func (p *pipeUploadWriter) Close() error {
+	if err := p.pipeWriter.Close(); err != nil {
+		return err
+	}
+	return <-p.done
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFSResolveRejectsEscape(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(filepath.Dir(root), "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newLocalFS(root)
+
+	cases := []string{"../secret.txt", "a/../../secret.txt", "../../../../etc/passwd"}
+	for _, p := range cases {
+		if _, err := fs.Open(p); !errors.Is(err, errPathEscapesRoot) {
+			t.Errorf("Open(%q): want errPathEscapesRoot, got %v", p, err)
+		}
+		if _, err := fs.Create(p); !errors.Is(err, errPathEscapesRoot) {
+			t.Errorf("Create(%q): want errPathEscapesRoot, got %v", p, err)
+		}
+		if err := fs.Delete(p); !errors.Is(err, errPathEscapesRoot) {
+			t.Errorf("Delete(%q): want errPathEscapesRoot, got %v", p, err)
+		}
+	}
+}
+
+func TestLocalFSResolveAllowsWithinRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := newLocalFS(root)
+
+	entry, err := fs.Stat("file.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if entry.Name != "file.txt" {
+		t.Errorf("Name = %q, want %q", entry.Name, "file.txt")
+	}
+}
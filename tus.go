@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const tusResumableVersion = "1.0.0"
+
+// tusUpload is the sidecar state persisted alongside the partial upload
+// file so that an interrupted transfer can be resumed later. TargetDir and
+// UseFileStorage record where finalizeTusUpload should deliver the
+// completed file, mirroring the uploadTarget resolved at creation time.
+type tusUpload struct {
+	ID             string `json:"id"`
+	Offset         int64  `json:"offset"`
+	Length         int64  `json:"length"`
+	Metadata       string `json:"metadata"`
+	TargetDir      string `json:"targetDir"`
+	UseFileStorage bool   `json:"useFileStorage"`
+}
+
+// target reconstructs the uploadTarget a finished tus upload should be
+// delivered to.
+func (u *tusUpload) target() uploadTarget {
+	if u.UseFileStorage {
+		return uploadTarget{storage: fileStorage, dir: u.TargetDir}
+	}
+	return uploadTarget{storage: newLocalFS(*outputDir), dir: u.TargetDir}
+}
+
+// newTusUploadID returns a random hex identifier suitable for naming the
+// sidecar and part files of a new upload.
+func newTusUploadID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// tusMetadataFilename decodes the "filename" entry of a tus Upload-Metadata
+// header (a comma-separated list of "key base64(value)" pairs), returning
+// "" if it's absent or malformed.
+func tusMetadataFilename(metadata string) string {
+	for _, pair := range strings.Split(metadata, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), " ")
+		if !ok || key != "filename" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+// Sidecar state and in-progress part files are always staged under
+// *outputDir, the same local scratch directory the legacy untargeted
+// upload path uses; only the finished, content-addressed file is delivered
+// to the resolved uploadTarget, by finalizeTusUpload.
+func tusStatePath(id string) string {
+	return path.Join(*outputDir, id+".tus")
+}
+
+func tusPartPath(id string) string {
+	return path.Join(*outputDir, id+".part")
+}
+
+func loadTusUpload(id string) (*tusUpload, error) {
+	data, err := os.ReadFile(tusStatePath(id))
+	if err != nil {
+		return nil, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func saveTusUpload(upload *tusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tusStatePath(upload.ID), data, 0o644)
+}
+
+func handleUploadsPost(w http.ResponseWriter, r *http.Request) {
+	lengthHeader := r.Header.Get("Upload-Length")
+	length, err := strconv.ParseInt(lengthHeader, 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Missing or invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	targetPath := getQueryValueOrDefault(r.URL.Query(), "p", "")
+	target, err := resolveUploadTarget(targetPath)
+	if errors.Is(err, errUploadNotAllowed) {
+		http.Error(w, "Upload not allowed for this directory", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, errPathEscapesRoot) {
+		http.Error(w, "Path escapes served directory", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errNonLocalBackend) {
+		http.Error(w, "Directory policy requires the local storage backend", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := newTusUploadID()
+	if err != nil {
+		log.Printf("Unable to generate upload id: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	upload := &tusUpload{
+		ID:             id,
+		Offset:         0,
+		Length:         length,
+		Metadata:       r.Header.Get("Upload-Metadata"),
+		TargetDir:      target.dir,
+		UseFileStorage: target.storage == fileStorage,
+	}
+
+	file, err := os.Create(tusPartPath(id))
+	if err != nil {
+		log.Printf("Unable to create tus part file: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	if err := file.Close(); err != nil {
+		log.Printf("Unable to close tus part file: %v", err)
+	}
+
+	if err := saveTusUpload(upload); err != nil {
+		log.Printf("Unable to save tus upload state: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Location", path.Join("/uploads", id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func handleUploadsHead(w http.ResponseWriter, r *http.Request, id string) {
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUploadsPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Unsupported Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+
+	file, err := os.OpenFile(tusPartPath(id), os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Unable to open tus part file: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Printf("Unable to close tus part file: %v", err)
+		}
+	}(file)
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		log.Printf("Unable to seek tus part file: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	written, err := io.Copy(file, r.Body)
+	if err != nil {
+		log.Printf("Unable to write tus chunk: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	upload.Offset += written
+	if err := saveTusUpload(upload); err != nil {
+		log.Printf("Unable to save tus upload state: %v", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Offset >= upload.Length {
+		if err := finalizeTusUpload(upload); err != nil {
+			log.Printf("Unable to finalize tus upload: %v", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleUploadsDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := loadTusUpload(id); err != nil {
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	if err := os.Remove(tusPartPath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to remove tus part file: %v", err)
+	}
+	if err := os.Remove(tusStatePath(id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Unable to remove tus state file: %v", err)
+	}
+
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeTusUpload delivers the completed part file to the uploadTarget
+// resolved when the upload was created, content-addressing and recording it
+// in the manifest the same way a regular multipart upload would, then
+// removes the local staging part and state files.
+func finalizeTusUpload(upload *tusUpload) error {
+	partFile, err := os.Open(tusPartPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer partFile.Close()
+
+	fileName := tusMetadataFilename(upload.Metadata)
+	if fileName == "" {
+		fileName = upload.ID
+	}
+
+	if _, err := storeContent(upload.target(), partFile, fileName, nil); err != nil {
+		return err
+	}
+
+	if err := os.Remove(tusPartPath(upload.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(tusStatePath(upload.ID))
+}
+
+func handleUploads(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusResumableVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusResumableVersion)
+		w.Header().Set("Tus-Extension", "creation")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		handleUploadsPost(w, r)
+		return
+	}
+
+	id := filepath.Base(r.URL.Path)
+	if id == "" || id == "." || id == "/" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		handleUploadsHead(w, r, id)
+	case http.MethodPatch:
+		handleUploadsPatch(w, r, id)
+	case http.MethodDelete:
+		handleUploadsDelete(w, r, id)
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var errUploadNotAllowed = errors.New("upload not allowed for this directory")
+
+// uploadTarget names where an upload should land: a Storage backend plus a
+// directory key relative to that backend's root.
+type uploadTarget struct {
+	storage Storage
+	dir     string
+}
+
+// resolveUploadTarget resolves the directory an upload should be written
+// to: if targetPath points at a directory under *serveDir that has upload
+// enabled, write there via fileStorage; otherwise fall back to the
+// historical behavior of always writing to *outputDir.
+func resolveUploadTarget(targetPath string) (uploadTarget, error) {
+	if targetPath == "" {
+		return uploadTarget{storage: newLocalFS(*outputDir), dir: "."}, nil
+	}
+
+	root, err := filepath.Abs(*serveDir)
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	fullPath, err := filepath.Abs(path.Join(*serveDir, targetPath))
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return uploadTarget{}, errPathEscapesRoot
+	}
+
+	policy, err := effectiveDirPolicy(fullPath)
+	if err != nil {
+		return uploadTarget{}, err
+	}
+	if !policy.Upload {
+		return uploadTarget{}, errUploadNotAllowed
+	}
+
+	return uploadTarget{storage: fileStorage, dir: filepath.ToSlash(rel)}, nil
+}
+
+const uploadManifestFileName = ".manifest.json"
+
+var uploadManifestMu sync.Mutex
+
+// recordUploadManifest records the original file name an upload with the
+// given content hash arrived as, so a canonical content-addressed file can
+// still be traced back to what the uploader called it.
+func recordUploadManifest(target uploadTarget, hash, originalName string) error {
+	uploadManifestMu.Lock()
+	defer uploadManifestMu.Unlock()
+
+	manifestPath := path.Join(target.dir, uploadManifestFileName)
+
+	manifest := map[string]string{}
+	if reader, err := target.storage.Open(manifestPath); err == nil {
+		data, readErr := io.ReadAll(reader)
+		reader.Close()
+		if readErr != nil {
+			return readErr
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	manifest[hash] = originalName
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	writer, err := target.storage.Create(manifestPath)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// parseSHA256Digest parses an RFC 3230 "Digest: sha-256=<base64>" header
+// value and returns the decoded digest bytes.
+func parseSHA256Digest(header string) ([]byte, error) {
+	for _, part := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "sha-256") {
+			continue
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	}
+	return nil, errors.New("no sha-256 digest present")
+}
+
+// uploadedFile is the outcome of content-addressing a single uploaded part.
+type uploadedFile struct {
+	Hash string `json:"hash"`
+	Name string `json:"filename"`
+	URL  string `json:"url"`
+}
+
+var errDigestMismatch = errors.New("uploaded content does not match Digest header")
+
+// storeUploadedPart streams part straight to target's backend writer,
+// hashing it along the way, then renames it to its content-addressed name.
+// If digest is non-nil, the computed hash must match it exactly.
+func storeUploadedPart(target uploadTarget, part *multipart.Part, digest []byte) (*uploadedFile, error) {
+	return storeContent(target, part, part.FileName(), digest)
+}
+
+// storeContent hashes r while streaming it into target's backend, then
+// renames it to its content-addressed name and records the original name in
+// the upload manifest. It underlies both the multipart upload path and tus
+// finalization. If digest is non-nil, the computed hash must match it
+// exactly.
+func storeContent(target uploadTarget, r io.Reader, fileName string, digest []byte) (*uploadedFile, error) {
+	tempName := path.Join(target.dir, ".upload-"+randomSuffix())
+
+	writer, err := target.storage.Create(tempName)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(writer, hasher), r)
+	closeErr := writer.Close()
+	if err != nil {
+		target.storage.Delete(tempName)
+		return nil, err
+	}
+	if closeErr != nil {
+		target.storage.Delete(tempName)
+		return nil, closeErr
+	}
+
+	sum := hasher.Sum(nil)
+	if digest != nil && !bytes.Equal(sum, digest) {
+		target.storage.Delete(tempName)
+		return nil, errDigestMismatch
+	}
+
+	hexHash := hex.EncodeToString(sum)
+	originalName := path.Base(fileName)
+	finalName := hexHash + path.Ext(originalName)
+	finalKey := path.Join(target.dir, finalName)
+
+	if _, err := target.storage.Stat(finalKey); err == nil {
+		target.storage.Delete(tempName)
+	} else if os.IsNotExist(err) {
+		if err := renameStorage(target.storage, tempName, finalKey); err != nil {
+			target.storage.Delete(tempName)
+			return nil, err
+		}
+	} else {
+		target.storage.Delete(tempName)
+		return nil, err
+	}
+
+	if err := recordUploadManifest(target, hexHash, originalName); err != nil {
+		return nil, err
+	}
+
+	return &uploadedFile{
+		Hash: hexHash,
+		Name: originalName,
+		URL:  canonicalDownloadURL(target, finalName),
+	}, nil
+}
+
+// canonicalDownloadURL builds the /download URL for a content-addressed
+// file when it was written through fileStorage (so it's reachable under
+// *serveDir); uploads to the legacy, unscoped *outputDir aren't necessarily
+// browsable, so they just get their storage key back.
+func canonicalDownloadURL(target uploadTarget, fileName string) string {
+	key := path.Join(target.dir, fileName)
+	if target.storage != fileStorage {
+		return key
+	}
+	url, err := addQueryToPath("/download", map[string]string{"p": key})
+	if err != nil {
+		return key
+	}
+	return url
+}
+
+// randomSuffix returns a short random hex string for naming temp upload
+// keys so concurrent uploads to the same directory don't collide.
+func randomSuffix() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "0"
+	}
+	return hex.EncodeToString(buf)
+}
+
+func handleUploadPost(w http.ResponseWriter, r *http.Request) {
+	var target uploadTarget
+	var haveTarget bool
+	var digest []byte
+	if header := r.Header.Get("Digest"); header != "" {
+		parsed, err := parseSHA256Digest(header)
+		if err != nil {
+			http.Error(w, "Unsupported Digest header", http.StatusBadRequest)
+			return
+		}
+		digest = parsed
+	}
+
+	var uploaded *uploadedFile
+
+	readPart := func(part *multipart.Part) (ok bool, err error) {
+		defer func(part *multipart.Part) {
+			err := part.Close()
+			if err != nil {
+				log.Printf("Unable to close file: %v", err)
+			}
+		}(part)
+
+		if part.FormName() == "p" {
+			value, err := io.ReadAll(part)
+			if err != nil {
+				return false, err
+			}
+			resolved, err := resolveUploadTarget(string(value))
+			if err != nil {
+				return false, err
+			}
+			target = resolved
+			haveTarget = true
+			return false, nil
+		}
+
+		if part.FormName() != "file" {
+			return false, nil
+		}
+
+		if !haveTarget {
+			target = uploadTarget{storage: newLocalFS(*outputDir), dir: "."}
+		}
+
+		log.Printf("Receive upload of file name %s into %s.", part.FileName(), target.dir)
+
+		result, err := storeUploadedPart(target, part, digest)
+		if err != nil {
+			return false, err
+		}
+		uploaded = result
+		return true, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Unable to read multipart form data", http.StatusBadRequest)
+		log.Printf("Unable to read multipart form data: %+v", err)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		ok, err := readPart(part)
+		if errors.Is(err, errUploadNotAllowed) {
+			http.Error(w, "Upload not allowed for this directory", http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, errPathEscapesRoot) {
+			http.Error(w, "Path escapes served directory", http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errNonLocalBackend) {
+			http.Error(w, "Directory policy requires the local storage backend", http.StatusNotImplemented)
+			return
+		}
+		if errors.Is(err, errDigestMismatch) {
+			http.Error(w, "Uploaded content does not match Digest header", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		if ok {
+			break
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("content-type", "application/json")
+		if err := json.NewEncoder(w).Encode(uploaded); err != nil {
+			log.Printf("Unable to encode upload response: %v", err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, uploaded.URL, http.StatusFound)
+}
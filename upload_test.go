@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestUploadTarget(t *testing.T) uploadTarget {
+	t.Helper()
+	return uploadTarget{storage: newLocalFS(t.TempDir()), dir: "."}
+}
+
+func TestStoreContentContentAddressesAndDedups(t *testing.T) {
+	target := newTestUploadTarget(t)
+
+	first, err := storeContent(target, strings.NewReader("hello world"), "greeting.txt", nil)
+	if err != nil {
+		t.Fatalf("storeContent: %v", err)
+	}
+	sum := sha256.Sum256([]byte("hello world"))
+	wantHash := hex.EncodeToString(sum[:])
+	if first.Hash != wantHash {
+		t.Errorf("Hash = %q, want %q", first.Hash, wantHash)
+	}
+	if first.Name != "greeting.txt" {
+		t.Errorf("Name = %q, want %q", first.Name, "greeting.txt")
+	}
+
+	second, err := storeContent(target, strings.NewReader("hello world"), "copy.txt", nil)
+	if err != nil {
+		t.Fatalf("storeContent (dup): %v", err)
+	}
+	if second.Hash != first.Hash {
+		t.Errorf("dup Hash = %q, want %q", second.Hash, first.Hash)
+	}
+
+	localRoot := target.storage.(*localFS)
+	entries, err := os.ReadDir(localRoot.root)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var contentFiles int
+	for _, e := range entries {
+		if e.Name() != uploadManifestFileName {
+			contentFiles++
+		}
+	}
+	if contentFiles != 1 {
+		t.Errorf("expected exactly 1 deduplicated content file, found %d", contentFiles)
+	}
+
+	data, err := os.ReadFile(filepath.Join(localRoot.root, uploadManifestFileName))
+	if err != nil {
+		t.Fatalf("reading manifest: %v", err)
+	}
+	var manifest map[string]string
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("unmarshal manifest: %v", err)
+	}
+	if manifest[wantHash] != "copy.txt" {
+		t.Errorf("manifest[%s] = %q, want %q (the most recent original name)", wantHash, manifest[wantHash], "copy.txt")
+	}
+}
+
+func TestStoreContentRejectsDigestMismatch(t *testing.T) {
+	target := newTestUploadTarget(t)
+
+	_, err := storeContent(target, strings.NewReader("hello world"), "greeting.txt", []byte("not the right digest"))
+	if !errors.Is(err, errDigestMismatch) {
+		t.Errorf("want errDigestMismatch, got %v", err)
+	}
+}